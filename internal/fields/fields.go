@@ -14,7 +14,17 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package fields defines and matches common struct fields.
+// Package fields defines and matches common struct fields. Matchers for
+// concepts that crossplane-runtime versions or forks, such as a managed
+// resource's ResourceSpec, are exposed as methods on a TypeRegistry so that
+// callers can select the API generation they are generating against.
+//
+// This package is detection only: it answers "does this type look like a
+// ResourceSpec/ProviderConfig/etc?". Emitting code from a positive match -
+// a --api-version flag, a ResolveReferences method, a provider-config
+// method set, Get/SetDeletionPolicy accessors - is the job of a generator
+// that walks packages using these matchers. No such generator exists in
+// this tree; wiring one in is tracked as follow-up work, not assumed done.
 package fields
 
 import (
@@ -36,24 +46,116 @@ const (
 	NameNonPortableClassSpecTemplate = "NonPortableClassSpecTemplate"
 	NamePortableClass                = "PortableClass"
 	NameItems                        = "Items"
+	NameProviderConfigSpec           = "ProviderConfigSpec"
+	NameProviderConfigStatus         = "ProviderConfigStatus"
+	NameProviderConfigUsage          = "ProviderConfigUsage"
+	NameDeletionPolicy               = "DeletionPolicy"
+	NameManagementPolicies           = "ManagementPolicies"
 )
 
-// Field type suffixes.
+// Field type suffixes for crossplane-runtime's resource lifecycle fields.
+// Only the modern ResourceSpec (see TypeRegistry.ResourceSpec) carries
+// ManagementPolicies. These suffixes name the common/v1 types and are not
+// part of a TypeRegistry, so IsDeletionPolicy and IsManagementPolicies match
+// only those import paths, whichever registry is in use.
 const (
-	TypeSuffixTypeMeta                     = "k8s.io/apimachinery/pkg/apis/meta/v1.TypeMeta"
-	TypeSuffixObjectMeta                   = "k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"
-	TypeSuffixListMeta                     = "k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"
-	TypeSuffixSpec                         = NameSpec
-	TypeSuffixSpecTemplate                 = NameSpecTemplate
-	TypeSuffixStatus                       = NameStatus
-	TypeSuffixResourceSpec                 = "github.com/crossplaneio/crossplane-runtime/apis/core/v1alpha1.ResourceSpec"
-	TypeSuffixResourceStatus               = "github.com/crossplaneio/crossplane-runtime/apis/core/v1alpha1.ResourceStatus"
-	TypeSuffixResourceClaimSpec            = "github.com/crossplaneio/crossplane-runtime/apis/core/v1alpha1.ResourceClaimSpec"
-	TypeSuffixResourceClaimStatus          = "github.com/crossplaneio/crossplane-runtime/apis/core/v1alpha1.ResourceClaimStatus"
-	TypeSuffixNonPortableClassSpecTemplate = "github.com/crossplaneio/crossplane-runtime/apis/core/v1alpha1.NonPortableClassSpecTemplate"
-	TypeSuffixPortableClass                = "github.com/crossplaneio/crossplane-runtime/apis/core/v1alpha1.PortableClass"
+	TypeSuffixDeletionPolicy     = "github.com/crossplane/crossplane-runtime/apis/common/v1.DeletionPolicy"
+	TypeSuffixManagementPolicies = "github.com/crossplane/crossplane-runtime/apis/common/v1.ManagementPolicies"
 )
 
+// IsDeletionPolicy returns a Matcher that returns true if the supplied field
+// appears to be a Crossplane resource's DeletionPolicy.
+func IsDeletionPolicy() Matcher { return IsTypeNamed(TypeSuffixDeletionPolicy, NameDeletionPolicy) }
+
+// IsManagementPolicies returns a Matcher that returns true if the supplied
+// field appears to be a Crossplane resource's ManagementPolicies.
+func IsManagementPolicies() Matcher {
+	return IsTypeNamed(TypeSuffixManagementPolicies, NameManagementPolicies)
+}
+
+// Field type suffixes that are not tied to a particular crossplane-runtime
+// API generation.
+const (
+	TypeSuffixTypeMeta     = "k8s.io/apimachinery/pkg/apis/meta/v1.TypeMeta"
+	TypeSuffixObjectMeta   = "k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"
+	TypeSuffixListMeta     = "k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"
+	TypeSuffixSpec         = NameSpec
+	TypeSuffixSpecTemplate = NameSpecTemplate
+	TypeSuffixStatus       = NameStatus
+)
+
+// A TypeRegistry holds the fully qualified type suffixes used to recognise
+// crossplane-runtime API concepts, e.g. a managed resource's ResourceSpec.
+// crossplane-runtime has shipped these types under more than one import
+// path - the original github.com/crossplaneio/crossplane-runtime v1alpha1
+// API, the modern github.com/crossplane/crossplane-runtime common/v1 API,
+// and various downstream forks. Selecting the TypeRegistry that matches the
+// API generation being generated for allows the rest of this package to
+// remain oblivious to which generation - or fork - is in play.
+type TypeRegistry struct {
+	TypeMeta                     string
+	ObjectMeta                   string
+	ListMeta                     string
+	ResourceSpec                 string
+	ResourceStatus               string
+	ResourceClaimSpec            string
+	ResourceClaimStatus          string
+	NonPortableClassSpecTemplate string
+	PortableClass                string
+	// ProviderConfigSpec is left empty by registries for API generations
+	// that have no common embeddable ProviderConfigSpec - e.g. the modern
+	// common/v1 API, which only defines ProviderConfigStatus and
+	// ProviderConfigUsage. IsProviderConfigSpec never matches when this is
+	// empty, rather than treating the empty suffix as "matches everything".
+	ProviderConfigSpec   string
+	ProviderConfigStatus string
+	ProviderConfigUsage  string
+}
+
+// NewV1alpha1Registry returns a TypeRegistry that recognises the original
+// github.com/crossplaneio/crossplane-runtime apis/core/v1alpha1 API types.
+func NewV1alpha1Registry() *TypeRegistry {
+	const pkg = "github.com/crossplaneio/crossplane-runtime/apis/core/v1alpha1"
+	return &TypeRegistry{
+		TypeMeta:                     TypeSuffixTypeMeta,
+		ObjectMeta:                   TypeSuffixObjectMeta,
+		ListMeta:                     TypeSuffixListMeta,
+		ResourceSpec:                 pkg + ".ResourceSpec",
+		ResourceStatus:               pkg + ".ResourceStatus",
+		ResourceClaimSpec:            pkg + ".ResourceClaimSpec",
+		ResourceClaimStatus:          pkg + ".ResourceClaimStatus",
+		NonPortableClassSpecTemplate: pkg + ".NonPortableClassSpecTemplate",
+		PortableClass:                pkg + ".PortableClass",
+		ProviderConfigSpec:           pkg + ".ProviderSpec",
+		ProviderConfigStatus:         pkg + ".ProviderStatus",
+		ProviderConfigUsage:          pkg + ".ProviderReference",
+	}
+}
+
+// NewV1Registry returns a TypeRegistry that recognises the modern
+// github.com/crossplane/crossplane-runtime apis/common/v1 API types.
+//
+// ProviderConfigSpec is left empty: unlike the legacy v1alpha1 line, the
+// modern common/v1 package has no common embeddable ProviderConfigSpec type
+// for a provider's own ProviderConfig spec to embed - only
+// ProviderConfigStatus and ProviderConfigUsage exist there.
+func NewV1Registry() *TypeRegistry {
+	const pkg = "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	return &TypeRegistry{
+		TypeMeta:                     TypeSuffixTypeMeta,
+		ObjectMeta:                   TypeSuffixObjectMeta,
+		ListMeta:                     TypeSuffixListMeta,
+		ResourceSpec:                 pkg + ".ResourceSpec",
+		ResourceStatus:               pkg + ".ResourceStatus",
+		ResourceClaimSpec:            pkg + ".ResourceClaimSpec",
+		ResourceClaimStatus:          pkg + ".ResourceClaimStatus",
+		NonPortableClassSpecTemplate: pkg + ".NonPortableClassSpecTemplate",
+		PortableClass:                pkg + ".PortableClass",
+		ProviderConfigStatus:         pkg + ".ProviderConfigStatus",
+		ProviderConfigUsage:          pkg + ".ProviderConfigUsage",
+	}
+}
+
 func matches(s *types.Struct, m Matcher) bool {
 	for i := 0; i < s.NumFields(); i++ {
 		if m(s.Field(i)) {
@@ -90,21 +192,25 @@ func Has(o types.Object, m ...Matcher) bool {
 }
 
 func findStruct(o types.Object) *types.Struct {
-	switch t := o.Type().Underlying().(type) {
+	return structOf(o.Type())
+}
+
+// structOf returns t's underlying struct, unwrapping a single level of
+// pointer indirection first so that e.g. an embedded *ResourceSpec field is
+// recognized the same way as an embedded ResourceSpec. types.Named.Underlying
+// is always fully resolved regardless of which package declared the named
+// type, so no further cross-package resolution is required here.
+func structOf(t types.Type) *types.Struct {
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	switch u := t.Underlying().(type) {
 	case *types.Struct:
-		return t
+		return u
 	case *types.Slice:
-		s, ok := t.Elem().Underlying().(*types.Struct)
-		if !ok {
-			return nil
-		}
-		return s
+		return structOf(u.Elem())
 	case *types.Map:
-		s, ok := t.Elem().Underlying().(*types.Struct)
-		if !ok {
-			return nil
-		}
-		return s
+		return structOf(u.Elem())
 	}
 	return nil
 }
@@ -180,36 +286,106 @@ func IsSpecTemplate() Matcher { return IsTypeNamed(NameSpecTemplate, TypeSuffixS
 func IsStatus() Matcher { return IsTypeNamed(NameStatus, TypeSuffixStatus) }
 
 // IsResourceSpec returns a Matcher that returns true if the supplied field
-// appears to be a Crossplane managed resource spec.
-func IsResourceSpec() Matcher { return IsTypeNamed(TypeSuffixResourceSpec, NameResourceSpec) }
+// appears to be a Crossplane managed resource spec, as defined by this
+// registry's API generation.
+func (r *TypeRegistry) IsResourceSpec() Matcher { return IsTypeNamed(r.ResourceSpec, NameResourceSpec) }
 
 // IsResourceStatus returns a Matcher that returns true if the supplied field
-// appears to be a Crossplane managed resource status.
-func IsResourceStatus() Matcher { return IsTypeNamed(TypeSuffixResourceStatus, NameResourceStatus) }
+// appears to be a Crossplane managed resource status, as defined by this
+// registry's API generation.
+func (r *TypeRegistry) IsResourceStatus() Matcher {
+	return IsTypeNamed(r.ResourceStatus, NameResourceStatus)
+}
 
-// IsResourceClaimSpec returns a Matcher that returns true if the supplied field
-// appears to be a Crossplane resource claim spec.
-func IsResourceClaimSpec() Matcher {
-	return IsTypeNamed(TypeSuffixResourceClaimSpec, NameResourceClaimSpec)
+// IsResourceClaimSpec returns a Matcher that returns true if the supplied
+// field appears to be a Crossplane resource claim spec, as defined by this
+// registry's API generation.
+func (r *TypeRegistry) IsResourceClaimSpec() Matcher {
+	return IsTypeNamed(r.ResourceClaimSpec, NameResourceClaimSpec)
 }
 
 // IsResourceClaimStatus returns a Matcher that returns true if the supplied
-// field appears to be a Crossplane resource claim status.
-func IsResourceClaimStatus() Matcher {
-	return IsTypeNamed(TypeSuffixResourceClaimStatus, NameStatus)
+// field appears to be a Crossplane resource claim status, as defined by this
+// registry's API generation.
+func (r *TypeRegistry) IsResourceClaimStatus() Matcher {
+	return IsTypeNamed(r.ResourceClaimStatus, NameStatus)
 }
 
 // IsNonPortableClassSpecTemplate returns a Matcher that returns true if the
 // supplied field appears to be a Crossplane non-portable resource class spec
-// template.
-func IsNonPortableClassSpecTemplate() Matcher {
-	return IsTypeNamed(TypeSuffixNonPortableClassSpecTemplate, NameNonPortableClassSpecTemplate)
+// template, as defined by this registry's API generation.
+func (r *TypeRegistry) IsNonPortableClassSpecTemplate() Matcher {
+	return IsTypeNamed(r.NonPortableClassSpecTemplate, NameNonPortableClassSpecTemplate)
 }
 
 // IsPortableClass returns a Matcher that returns true if the supplied field
-// appears to be a Crossplane portable resource class.
-func IsPortableClass() Matcher {
-	return IsTypeNamed(TypeSuffixPortableClass, NamePortableClass)
+// appears to be a Crossplane portable resource class, as defined by this
+// registry's API generation.
+func (r *TypeRegistry) IsPortableClass() Matcher {
+	return IsTypeNamed(r.PortableClass, NamePortableClass)
+}
+
+// IsProviderConfigSpec returns a Matcher that returns true if the supplied
+// field appears to be a Crossplane ProviderConfig spec, as defined by this
+// registry's API generation. A provider's composite spec type embeds the
+// runtime ProviderConfigSpec under a field named after its own type, the
+// same convention ResourceSpec uses.
+func (r *TypeRegistry) IsProviderConfigSpec() Matcher {
+	if r.ProviderConfigSpec == "" {
+		return func(*types.Var) bool { return false }
+	}
+	return IsTypeNamed(r.ProviderConfigSpec, NameProviderConfigSpec)
+}
+
+// IsProviderConfigStatus returns a Matcher that returns true if the supplied
+// field appears to be a Crossplane ProviderConfig status, as defined by this
+// registry's API generation, embedded the same way as IsProviderConfigSpec.
+func (r *TypeRegistry) IsProviderConfigStatus() Matcher {
+	return IsTypeNamed(r.ProviderConfigStatus, NameProviderConfigStatus)
+}
+
+// IsProviderConfigUsage returns a Matcher that returns true if the supplied
+// field appears to be a Crossplane ProviderConfigUsage, as defined by this
+// registry's API generation.
+func (r *TypeRegistry) IsProviderConfigUsage() Matcher {
+	return IsTypeNamed(r.ProviderConfigUsage, NameProviderConfigUsage)
+}
+
+// IsProviderConfigUsageList returns a Matcher that returns true if the
+// supplied field is the Items of a ProviderConfigUsageList, i.e. a slice
+// whose elements embed a Crossplane ProviderConfigUsage (as defined by this
+// registry's API generation), the same way a provider-specific
+// ProviderConfigUsage wraps the runtime type alongside its own TypeMeta and
+// ObjectMeta.
+func (r *TypeRegistry) IsProviderConfigUsageList() Matcher {
+	return func(f *types.Var) bool {
+		if !IsItems()(f) {
+			return false
+		}
+		s, ok := f.Type().Underlying().(*types.Slice)
+		if !ok {
+			return false
+		}
+		elem := structOf(s.Elem())
+		if elem == nil {
+			return false
+		}
+		return matches(elem, r.IsProviderConfigUsage())
+	}
+}
+
+// HasManagementPoliciesField returns a Matcher that returns true if the
+// supplied field is itself a ManagementPolicies, or is a ResourceSpec (as
+// defined by this registry's API generation) that has one - i.e. the
+// spec honours management policies, whether directly or via its embedded
+// ResourceSpec.
+func (r *TypeRegistry) HasManagementPoliciesField() Matcher {
+	return func(f *types.Var) bool {
+		if IsManagementPolicies()(f) {
+			return true
+		}
+		return r.IsResourceSpec()(f) && HasFieldThat(IsManagementPolicies())(f)
+	}
 }
 
 // IsItems returns a Matcher that returns true if the supplied field appears to
@@ -217,3 +393,91 @@ func IsPortableClass() Matcher {
 func IsItems() Matcher {
 	return IsNamed(NameItems)
 }
+
+// Field type suffixes for crossplane-runtime's generic reference resolution
+// types. Unlike ResourceSpec and friends these have not moved between API
+// generations, so they are not part of a TypeRegistry.
+const (
+	TypeSuffixReference = "github.com/crossplane/crossplane-runtime/apis/common/v1.Reference"
+	TypeSuffixSelector  = "github.com/crossplane/crossplane-runtime/apis/common/v1.Selector"
+)
+
+// IsReference returns a Matcher that returns true if the supplied field is a
+// crossplane-runtime Reference, used to resolve another field's value from a
+// referenced resource.
+func IsReference() Matcher {
+	return func(f *types.Var) bool {
+		return strings.HasSuffix(f.Type().String(), TypeSuffixReference)
+	}
+}
+
+// IsReferenceSlice returns a Matcher that returns true if the supplied field
+// is a slice of crossplane-runtime Reference, used to resolve another slice
+// field's values from referenced resources.
+func IsReferenceSlice() Matcher {
+	return func(f *types.Var) bool {
+		s, ok := f.Type().Underlying().(*types.Slice)
+		if !ok {
+			return false
+		}
+		return strings.HasSuffix(s.Elem().String(), TypeSuffixReference)
+	}
+}
+
+// IsSelector returns a Matcher that returns true if the supplied field is a
+// crossplane-runtime Selector, used to select a referenced resource by
+// labels rather than by name.
+func IsSelector() Matcher {
+	return func(f *types.Var) bool {
+		return strings.HasSuffix(f.Type().String(), TypeSuffixSelector)
+	}
+}
+
+// HasReferencePair returns true if the supplied struct has a field named
+// name plus the Reference and Selector fields crossplane-runtime's
+// reference.Resolve and reference.ResolveMultiple helpers need to resolve
+// name's value from a referenced resource. For a scalar name (e.g. VPCID)
+// these are name + "Ref" and name + "Selector" (VPCIDRef, VPCIDSelector).
+// For a slice-typed name (e.g. SubnetIDs) Crossplane convention drops the
+// plural "s" before appending "Refs"/"Selector" - SubnetIDRefs, not
+// SubnetIDsRef - so HasReferencePair inspects name's own field to tell
+// which form to look for, rather than guessing from the string name alone.
+//
+// HasReferencePair takes a *types.Struct rather than returning a Matcher
+// because, unlike the single-field Matcher predicates above, it must
+// compare three sibling fields at once - something a func(*types.Var) bool
+// cannot do, since a struct field's Var does not retain a reference back to
+// the struct that declares it. This is a deliberate, documented deviation
+// from a Matcher-returning signature, not an oversight: there is no way to
+// express a multi-field comparison as a single-field predicate without
+// either mutable state shared across Matcher invocations (unsafe to reuse,
+// unlike every other Matcher in this file) or widening the Matcher type
+// itself (which would break every existing caller).
+func HasReferencePair(s *types.Struct, name string) bool {
+	field := func(fieldName string) *types.Var {
+		for i := 0; i < s.NumFields(); i++ {
+			if f := s.Field(i); f.Name() == fieldName {
+				return f
+			}
+		}
+		return nil
+	}
+
+	scalar := field(name)
+	if scalar == nil {
+		return false
+	}
+
+	root, refSuffix := name, "Ref"
+	if IsSlice()(scalar) {
+		root, refSuffix = strings.TrimSuffix(name, "s"), "Refs"
+	}
+
+	ref := field(root + refSuffix)
+	if ref == nil || !(IsReference()(ref) || IsReferenceSlice()(ref)) {
+		return false
+	}
+
+	sel := field(root + "Selector")
+	return sel != nil && IsSelector()(sel)
+}
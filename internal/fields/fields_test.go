@@ -0,0 +1,225 @@
+/*
+Copyright 2019 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fields
+
+import (
+	"go/types"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/packages/packagestest"
+)
+
+// TestIsProviderConfigSpecAndStatus confirms the registry matches a
+// ProviderConfig's embedded spec and status by the field being named after
+// its own type, the same embedding convention ResourceSpec and
+// ResourceStatus use elsewhere in this package - not by the field being
+// named "Spec" or "Status", which is how IsSpec and IsStatus match a CRD's
+// own outer spec/status fields instead.
+func TestIsProviderConfigSpecAndStatus(t *testing.T) {
+	pkg := types.NewPackage("example.com/fixture/v1", "v1")
+	spec := types.NewNamed(types.NewTypeName(0, pkg, "ProviderConfigSpec", nil), types.NewStruct(nil, nil), nil)
+	status := types.NewNamed(types.NewTypeName(0, pkg, "ProviderConfigStatus", nil), types.NewStruct(nil, nil), nil)
+
+	r := &TypeRegistry{
+		ProviderConfigSpec:   "example.com/fixture/v1.ProviderConfigSpec",
+		ProviderConfigStatus: "example.com/fixture/v1.ProviderConfigStatus",
+	}
+
+	owner := types.NewPackage("example.com/fixture", "fixture")
+	specField := types.NewField(0, owner, "Spec", spec, false)
+	statusField := types.NewField(0, owner, "Status", status, false)
+	embeddedSpec := types.NewField(0, owner, "ProviderConfigSpec", spec, false)
+	embeddedStatus := types.NewField(0, owner, "ProviderConfigStatus", status, false)
+
+	if r.IsProviderConfigSpec()(specField) {
+		t.Error("IsProviderConfigSpec()(Spec field): want false, got true")
+	}
+	if !r.IsProviderConfigSpec()(embeddedSpec) {
+		t.Error("IsProviderConfigSpec()(field named ProviderConfigSpec): want true, got false")
+	}
+	if r.IsProviderConfigStatus()(statusField) {
+		t.Error("IsProviderConfigStatus()(Status field): want false, got true")
+	}
+	if !r.IsProviderConfigStatus()(embeddedStatus) {
+		t.Error("IsProviderConfigStatus()(field named ProviderConfigStatus): want true, got false")
+	}
+}
+
+// TestIsProviderConfigSpecEmptySuffixNeverMatches confirms that a registry
+// without a common embeddable ProviderConfigSpec, such as NewV1Registry,
+// never matches any field - an empty suffix must not be treated as
+// "matches everything".
+func TestIsProviderConfigSpecEmptySuffixNeverMatches(t *testing.T) {
+	pkg := types.NewPackage("example.com/fixture/v1", "v1")
+	spec := types.NewNamed(types.NewTypeName(0, pkg, "ProviderConfigSpec", nil), types.NewStruct(nil, nil), nil)
+
+	r := &TypeRegistry{}
+	owner := types.NewPackage("example.com/fixture", "fixture")
+	embeddedSpec := types.NewField(0, owner, "ProviderConfigSpec", spec, false)
+
+	if r.IsProviderConfigSpec()(embeddedSpec) {
+		t.Error("IsProviderConfigSpec()(field named ProviderConfigSpec) with empty registry suffix: want false, got true")
+	}
+}
+
+// TestHasReferencePair covers both the scalar naming convention (VPCID ->
+// VPCIDRef, VPCIDSelector) and the slice naming convention (SubnetIDs ->
+// SubnetIDRefs, SubnetIDSelector - the plural "s" moves, it does not grow a
+// second one), since HasReferencePair must pick the right convention by
+// inspecting the named field rather than guessing from the string alone.
+func TestHasReferencePair(t *testing.T) {
+	runtime := types.NewPackage("github.com/crossplane/crossplane-runtime/apis/common/v1", "v1")
+	reference := types.NewNamed(types.NewTypeName(0, runtime, "Reference", nil), types.NewStruct(nil, nil), nil)
+	selector := types.NewNamed(types.NewTypeName(0, runtime, "Selector", nil), types.NewStruct(nil, nil), nil)
+
+	owner := types.NewPackage("example.com/fixture", "fixture")
+	field := func(name string, t types.Type) *types.Var { return types.NewField(0, owner, name, t, false) }
+
+	strSlice := types.NewSlice(types.Typ[types.String])
+	refPtr := types.NewPointer(reference)
+	refSlice := types.NewSlice(refPtr)
+	selPtr := types.NewPointer(selector)
+
+	cases := map[string]struct {
+		fields []*types.Var
+		name   string
+		want   bool
+	}{
+		"ScalarPairPresent": {
+			fields: []*types.Var{
+				field("VPCID", types.Typ[types.String]),
+				field("VPCIDRef", refPtr),
+				field("VPCIDSelector", selPtr),
+			},
+			name: "VPCID",
+			want: true,
+		},
+		"SlicePairPresent": {
+			fields: []*types.Var{
+				field("SubnetIDs", strSlice),
+				field("SubnetIDRefs", refSlice),
+				field("SubnetIDSelector", selPtr),
+			},
+			name: "SubnetIDs",
+			want: true,
+		},
+		"SliceRefNamedWithTrailingSBeforeRefIsNotMatched": {
+			fields: []*types.Var{
+				field("SubnetIDs", strSlice),
+				field("SubnetIDsRef", refSlice),
+				field("SubnetIDSelector", selPtr),
+			},
+			name: "SubnetIDs",
+			want: false,
+		},
+		"MissingSelector": {
+			fields: []*types.Var{
+				field("VPCID", types.Typ[types.String]),
+				field("VPCIDRef", refPtr),
+			},
+			name: "VPCID",
+			want: false,
+		},
+		"MissingScalar": {
+			fields: []*types.Var{
+				field("VPCIDRef", refPtr),
+				field("VPCIDSelector", selPtr),
+			},
+			name: "VPCID",
+			want: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := types.NewStruct(tc.fields, nil)
+			if got := HasReferencePair(s, tc.name); got != tc.want {
+				t.Errorf("HasReferencePair(s, %q): want %t, got %t", tc.name, tc.want, got)
+			}
+		})
+	}
+}
+
+// TestHasAcrossFilesAndPackages loads a fixture split across two packages -
+// a.Resource has a Spec field of type *b.Spec - and confirms HasFieldThat
+// can follow that field once findStruct unwraps the pointer. Before that fix
+// findStruct saw a *types.Pointer for the Spec field, not a *types.Struct, so
+// HasFieldThat never matched anything inside b.Spec.
+func TestHasAcrossFilesAndPackages(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{
+		{
+			Name: "example.com/fixture",
+			Files: map[string]interface{}{
+				"a/a.go": `package a
+
+import "example.com/fixture/b"
+
+type Resource struct {
+	Spec *b.Spec
+}
+`,
+				"b/b.go": `package b
+
+type Spec struct {
+	Foo string
+}
+`,
+			},
+		},
+	})
+	defer exported.Cleanup()
+
+	cfg := exported.Config
+	cfg.Mode = packages.NeedName | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax | packages.NeedDeps
+
+	pkgs, err := packages.Load(cfg, "example.com/fixture/a")
+	if err != nil {
+		t.Fatalf("packages.Load(...): %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		t.Fatal("packages.Load(...) produced errors")
+	}
+
+	obj := pkgs[0].Types.Scope().Lookup("Resource")
+	if obj == nil {
+		t.Fatal("Resource type not found in loaded package")
+	}
+
+	cases := map[string]struct {
+		m    Matcher
+		want bool
+	}{
+		"MatchesFieldOfPointerToStructFromOtherPackage": {
+			m:    HasFieldThat(IsNamed("Foo")),
+			want: true,
+		},
+		"NoMatchForFieldThatDoesNotExist": {
+			m:    HasFieldThat(IsNamed("Bar")),
+			want: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := Has(obj, tc.m)
+			if got != tc.want {
+				t.Errorf("Has(...): want %t, got %t", tc.want, got)
+			}
+		})
+	}
+}